@@ -0,0 +1,204 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package triton
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AuthConfig configures signing of Triton SD requests using the Joyent HTTP
+// Signature scheme (https://github.com/joyent/node-http-signature), as
+// required by CloudAPI and Manta-fronted discovery endpoints.
+type AuthConfig struct {
+	Account     string `yaml:"account"`
+	KeyID       string `yaml:"key_id"`
+	KeyFile     string `yaml:"key_file,omitempty"`
+	UseSSHAgent bool   `yaml:"use_ssh_agent,omitempty"`
+}
+
+// Validate checks that the auth configuration is internally consistent.
+func (c *AuthConfig) Validate() error {
+	if c.Account == "" {
+		return errors.New("triton SD auth configuration requires an account")
+	}
+	if c.KeyID == "" {
+		return errors.New("triton SD auth configuration requires a key_id")
+	}
+	if c.UseSSHAgent && c.KeyFile != "" {
+		return errors.New("triton SD auth configuration must not set both key_file and use_ssh_agent")
+	}
+	if !c.UseSSHAgent && c.KeyFile == "" {
+		return errors.New("triton SD auth configuration requires either a key_file or use_ssh_agent")
+	}
+	return nil
+}
+
+// requestSigner produces Joyent HTTP Signature "Authorization" header values.
+// The keyID is the "/<account>/keys/<fingerprint>" identifier the server
+// uses to look up the matching public key; sign performs the actual
+// rsa-sha256 signing operation, which may or may not require the private
+// key to be resident in this process.
+type requestSigner struct {
+	keyID string
+	sign  func(data []byte) ([]byte, error)
+}
+
+// newRequestSigner builds a requestSigner from the given auth configuration,
+// loading a private key from disk or, if use_ssh_agent is set, locating a
+// matching key in a running ssh-agent.
+func newRequestSigner(c AuthConfig) (*requestSigner, error) {
+	if c.UseSSHAgent {
+		return newSSHAgentSigner(c)
+	}
+	return newKeyFileSigner(c)
+}
+
+func newKeyFileSigner(c AuthConfig) (*requestSigner, error) {
+	data, err := ioutil.ReadFile(c.KeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading triton SD key_file")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("error decoding triton SD key_file: not a PEM encoded file")
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err2 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err2 != nil {
+			return nil, errors.Wrap(err, "error parsing triton SD key_file")
+		}
+		rsaKey, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("triton SD key_file must contain an RSA private key")
+		}
+		key = rsaKey
+	}
+
+	return &requestSigner{
+		keyID: fmt.Sprintf("/%s/keys/%s", c.Account, c.KeyID),
+		sign: func(data []byte) ([]byte, error) {
+			digest := sha256.Sum256(data)
+			return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		},
+	}, nil
+}
+
+// newSSHAgentSigner locates the key identified by c.KeyID (its legacy MD5 or
+// SHA256 fingerprint) in the ssh-agent listening on SSH_AUTH_SOCK and returns
+// a requestSigner that delegates signing to the agent, so that the private
+// key itself never has to be read into this process.
+func newSSHAgentSigner(c AuthConfig) (*requestSigner, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errors.New("use_ssh_agent is set but SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, errors.Wrap(err, "error connecting to ssh-agent")
+	}
+
+	ag := agent.NewClient(conn)
+	keys, err := ag.List()
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "error listing ssh-agent keys")
+	}
+
+	var signKey ssh.PublicKey
+	for _, k := range keys {
+		if ssh.FingerprintLegacyMD5(k) == c.KeyID || ssh.FingerprintSHA256(k) == c.KeyID {
+			signKey = k
+			break
+		}
+	}
+	if signKey == nil {
+		conn.Close()
+		return nil, errors.Errorf("no key matching fingerprint %q found in ssh-agent", c.KeyID)
+	}
+
+	return &requestSigner{
+		keyID: fmt.Sprintf("/%s/keys/%s", c.Account, c.KeyID),
+		sign: func(data []byte) ([]byte, error) {
+			if ext, ok := ag.(agent.ExtendedAgent); ok {
+				sig, err := ext.SignWithFlags(signKey, data, agent.SignatureFlagRsaSha256)
+				if err != nil {
+					return nil, err
+				}
+				return sig.Blob, nil
+			}
+			sig, err := ag.Sign(signKey, data)
+			if err != nil {
+				return nil, err
+			}
+			return sig.Blob, nil
+		},
+	}, nil
+}
+
+// signingTransport wraps an http.RoundTripper, adding a Date header and a
+// Joyent HTTP Signature Authorization header to every outgoing request. It
+// only ever signs the date header, which is sufficient for CloudAPI and
+// keeps the signing string independent of proxies rewriting the request.
+type signingTransport struct {
+	next   http.RoundTripper
+	signer *requestSigner
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	date := time.Now().UTC().Format(http.TimeFormat)
+
+	sig, err := t.signer.sign([]byte("date: " + date))
+	if err != nil {
+		return nil, errors.Wrap(err, "error signing triton SD request")
+	}
+	auth := fmt.Sprintf(
+		`Signature keyId="%s",algorithm="rsa-sha256",headers="date",signature="%s"`,
+		t.signer.keyID, base64.StdEncoding.EncodeToString(sig),
+	)
+
+	// http.RoundTripper implementations must not mutate the request they
+	// are given, so clone it before adding headers.
+	signed := new(http.Request)
+	*signed = *req
+	signed.Header = make(http.Header, len(req.Header)+2)
+	for k, v := range req.Header {
+		signed.Header[k] = v
+	}
+	signed.Header.Set("Date", date)
+	signed.Header.Set("Authorization", auth)
+
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return next.RoundTrip(signed)
+}
@@ -0,0 +1,271 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package triton
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startFakeAgent starts an in-process ssh-agent, backed by the given key,
+// listening on a temporary unix socket. It returns the socket path and a
+// cleanup function.
+func startFakeAgent(t *testing.T, key *rsa.PrivateKey) (string, func()) {
+	dir, err := ioutil.TempDir("", "triton_sd_agent")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %s", err)
+	}
+
+	sockPath := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("error listening on fake agent socket: %s", err)
+	}
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		l.Close()
+		os.RemoveAll(dir)
+		t.Fatalf("error adding key to fake agent: %s", err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	return sockPath, func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNewSSHAgentSigner(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating test key: %s", err)
+	}
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("error deriving public key: %s", err)
+	}
+	fingerprint := ssh.FingerprintLegacyMD5(pub)
+
+	sockPath, cleanup := startFakeAgent(t, key)
+	defer cleanup()
+
+	oldSock := os.Getenv("SSH_AUTH_SOCK")
+	os.Setenv("SSH_AUTH_SOCK", sockPath)
+	defer os.Setenv("SSH_AUTH_SOCK", oldSock)
+
+	signer, err := newRequestSigner(AuthConfig{
+		Account:     "testaccount",
+		KeyID:       fingerprint,
+		UseSSHAgent: true,
+	})
+	if err != nil {
+		t.Fatalf("error building ssh-agent signer: %s", err)
+	}
+
+	wantKeyID := "/testaccount/keys/" + fingerprint
+	if signer.keyID != wantKeyID {
+		t.Fatalf("keyID = %q, want %q", signer.keyID, wantKeyID)
+	}
+
+	data := []byte("date: Thu, 05 Jan 2017 20:51:31 GMT")
+	sig, err := signer.sign(data)
+	if err != nil {
+		t.Fatalf("error signing via fake agent: %s", err)
+	}
+	if len(sig) == 0 {
+		t.Fatal("expected a non-empty signature from the fake agent")
+	}
+}
+
+func TestNewSSHAgentSignerNoMatchingKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating test key: %s", err)
+	}
+
+	sockPath, cleanup := startFakeAgent(t, key)
+	defer cleanup()
+
+	oldSock := os.Getenv("SSH_AUTH_SOCK")
+	os.Setenv("SSH_AUTH_SOCK", sockPath)
+	defer os.Setenv("SSH_AUTH_SOCK", oldSock)
+
+	_, err = newRequestSigner(AuthConfig{
+		Account:     "testaccount",
+		KeyID:       "aa:bb:cc:dd",
+		UseSSHAgent: true,
+	})
+	if err == nil {
+		t.Fatal("expected an error for a fingerprint not present in the agent")
+	}
+}
+
+func TestNewKeyFileSigner(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating test key: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "triton_sd_keyfile")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "key.pem")
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("error writing test key file: %s", err)
+	}
+
+	signer, err := newKeyFileSigner(AuthConfig{
+		Account: "testaccount",
+		KeyID:   "de:ad:be:ef",
+		KeyFile: keyFile,
+	})
+	if err != nil {
+		t.Fatalf("error building key_file signer: %s", err)
+	}
+
+	wantKeyID := "/testaccount/keys/de:ad:be:ef"
+	if signer.keyID != wantKeyID {
+		t.Fatalf("keyID = %q, want %q", signer.keyID, wantKeyID)
+	}
+
+	data := []byte("date: Thu, 05 Jan 2017 20:51:31 GMT")
+	sig, err := signer.sign(data)
+	if err != nil {
+		t.Fatalf("error signing via key_file signer: %s", err)
+	}
+
+	digest := sha256.Sum256(data)
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+		t.Fatalf("signature does not verify against the test key: %s", err)
+	}
+}
+
+// roundTripFunc adapts a function to an http.RoundTripper, so tests can
+// observe the request signingTransport hands off without a real network
+// round trip.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestSigningTransportRoundTrip(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating test key: %s", err)
+	}
+
+	signer := &requestSigner{
+		keyID: "/testaccount/keys/de:ad:be:ef",
+		sign: func(data []byte) ([]byte, error) {
+			digest := sha256.Sum256(data)
+			return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		},
+	}
+
+	var gotReq *http.Request
+	transport := &signingTransport{
+		signer: signer,
+		next: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			gotReq = r
+			return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+		}),
+	}
+
+	req, err := http.NewRequest("GET", "https://example.org/test", nil)
+	if err != nil {
+		t.Fatalf("error building test request: %s", err)
+	}
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip: %s", err)
+	}
+
+	if req.Header.Get("Date") != "" || req.Header.Get("Authorization") != "" {
+		t.Fatal("RoundTrip must not mutate the original request")
+	}
+
+	date := gotReq.Header.Get("Date")
+	if date == "" {
+		t.Fatal("expected a Date header on the signed request")
+	}
+
+	digest := sha256.Sum256([]byte("date: " + date))
+	wantSig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("error computing expected signature: %s", err)
+	}
+	wantAuth := fmt.Sprintf(
+		`Signature keyId="/testaccount/keys/de:ad:be:ef",algorithm="rsa-sha256",headers="date",signature="%s"`,
+		base64.StdEncoding.EncodeToString(wantSig),
+	)
+
+	if got := gotReq.Header.Get("Authorization"); got != wantAuth {
+		t.Fatalf("Authorization = %q, want %q", got, wantAuth)
+	}
+}
+
+func TestAuthConfigValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		cfg     AuthConfig
+		wantErr bool
+	}{
+		{"missing account", AuthConfig{KeyID: "x", UseSSHAgent: true}, true},
+		{"missing key_id", AuthConfig{Account: "a", UseSSHAgent: true}, true},
+		{"both key_file and use_ssh_agent", AuthConfig{Account: "a", KeyID: "x", KeyFile: "k", UseSSHAgent: true}, true},
+		{"neither key_file nor use_ssh_agent", AuthConfig{Account: "a", KeyID: "x"}, true},
+		{"valid ssh agent", AuthConfig{Account: "a", KeyID: "x", UseSSHAgent: true}, false},
+		{"valid key file", AuthConfig{Account: "a", KeyID: "x", KeyFile: "k"}, false},
+	}
+	for _, c := range cases {
+		err := c.cfg.Validate()
+		if c.wantErr && err == nil {
+			t.Errorf("%s: expected error, got nil", c.name)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("%s: expected no error, got %s", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package triton
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/util/strutil"
+)
+
+// cloudAPIPageSize is the number of machines requested per ListMachines
+// page. CloudAPI caps this at 1000.
+const cloudAPIPageSize = 1000
+
+// cloudAPIMachine models a single machine entry returned by CloudAPI's
+// ListMachines (GET /:account/machines). "ips" and "networks" are parallel
+// arrays: ips[i] is the address the machine holds on networks[i].
+type cloudAPIMachine struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Brand     string            `json:"brand"`
+	State     string            `json:"state"`
+	Image     string            `json:"image"`
+	Package   string            `json:"package"`
+	PrimaryIP string            `json:"primaryIp"`
+	IPs       []string          `json:"ips"`
+	Networks  []string          `json:"networks"`
+	Tags      map[string]string `json:"tags"`
+	DNSNames  []string          `json:"dns_names"`
+}
+
+// cloudAPINetwork models a single network entry returned by CloudAPI's
+// ListNetworks (GET /:account/networks), used only to resolve the friendly
+// name behind the network UUIDs listed on a machine.
+type cloudAPINetwork struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// refreshCloudAPI performs Triton SD by listing machines directly from
+// CloudAPI, rather than hitting the Container Monitor discovery endpoint
+// used by the 'vm' and 'gz' server types.
+func (d *Discovery) refreshCloudAPI(ctx context.Context) ([]*targetgroup.Group, error) {
+	endpoint := fmt.Sprintf("https://%s:%d/%s/machines", d.sdConfig.Endpoint, d.sdConfig.Port, d.sdConfig.Account)
+
+	query := url.Values{"limit": {strconv.Itoa(cloudAPIPageSize)}}
+	for k, v := range d.sdConfig.Tags {
+		query.Set("tag."+k, v)
+	}
+
+	var machines []cloudAPIMachine
+	next := endpoint + "?" + query.Encode()
+	for next != "" {
+		page, link, count, err := d.fetchCloudAPIPage(ctx, next)
+		if err != nil {
+			return nil, err
+		}
+		machines = append(machines, page...)
+
+		switch {
+		case link != "":
+			next = link
+		case count > len(machines):
+			query.Set("offset", strconv.Itoa(len(machines)))
+			next = endpoint + "?" + query.Encode()
+		default:
+			next = ""
+		}
+	}
+
+	// Network UUIDs aren't human-friendly, so resolve them to names via
+	// ListNetworks, but only pay for the extra round trip if some machine
+	// actually has networks to label.
+	var networkNames map[string]string
+	for _, m := range machines {
+		if len(m.Networks) > 0 {
+			var err error
+			networkNames, err = d.fetchCloudAPINetworkNames(ctx)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	return d.processCloudAPIResponse(machines, networkNames, endpoint)
+}
+
+// fetchCloudAPINetworkNames lists the account's networks and returns a map
+// of network UUID to network name.
+func (d *Discovery) fetchCloudAPINetworkNames(ctx context.Context) (map[string]string, error) {
+	endpoint := fmt.Sprintf("https://%s:%d/%s/networks", d.sdConfig.Endpoint, d.sdConfig.Port, d.sdConfig.Account)
+	query := url.Values{"limit": {strconv.Itoa(cloudAPIPageSize)}}
+
+	names := make(map[string]string)
+	fetched := 0
+	next := endpoint + "?" + query.Encode()
+	for next != "" {
+		data, link, count, err := d.getCloudAPIPage(ctx, next)
+		if err != nil {
+			return nil, err
+		}
+
+		var page []cloudAPINetwork
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, errors.Wrap(err, "an error occurred unmarshaling the cloudapi networks response json")
+		}
+		for _, n := range page {
+			names[n.ID] = n.Name
+		}
+		fetched += len(page)
+
+		switch {
+		case link != "":
+			next = link
+		case count > fetched:
+			query.Set("offset", strconv.Itoa(fetched))
+			next = endpoint + "?" + query.Encode()
+		default:
+			next = ""
+		}
+	}
+
+	return names, nil
+}
+
+// fetchCloudAPIPage requests a single page of machines and returns it
+// alongside the next page's URL, if any Link header advertised one, and the
+// total number of matching machines reported via x-resource-count.
+func (d *Discovery) fetchCloudAPIPage(ctx context.Context, endpoint string) ([]cloudAPIMachine, string, int, error) {
+	data, link, count, err := d.getCloudAPIPage(ctx, endpoint)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	var page []cloudAPIMachine
+	if err := json.Unmarshal(data, &page); err != nil {
+		return nil, "", 0, errors.Wrap(err, "an error occurred unmarshaling the cloudapi discovery response json")
+	}
+
+	return page, link, count, nil
+}
+
+// getCloudAPIPage requests a single CloudAPI listing page and returns its
+// raw body alongside the next page's URL, if any Link header advertised
+// one, and the total number of matching items reported via
+// x-resource-count. It is shared by the machines and networks listings.
+func (d *Discovery) getCloudAPIPage(ctx context.Context, endpoint string) ([]byte, string, int, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	req = req.WithContext(ctx)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, "", 0, errors.Wrap(err, "an error occurred when requesting targets from the discovery endpoint")
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if (resp.StatusCode / 100) != 2 {
+		return nil, "", 0, errors.New("an error occurred when requesting targets from the discovery endpoint")
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", 0, errors.Wrap(err, "an error occurred when reading the response body")
+	}
+
+	count, _ := strconv.Atoi(resp.Header.Get("x-resource-count"))
+	return data, nextLinkURL(resp.Header.Get("Link")), count, nil
+}
+
+// nextLinkURL extracts the rel="next" target from an RFC 5988 Link header,
+// returning "" if there isn't one.
+func nextLinkURL(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+func (d *Discovery) processCloudAPIResponse(machines []cloudAPIMachine, networkNames map[string]string, endpoint string) ([]*targetgroup.Group, error) {
+	tg := &targetgroup.Group{
+		Source: endpoint,
+	}
+
+	for _, machine := range machines {
+		addr, err := d.cloudAPIAddress(machine)
+		if err != nil {
+			return nil, err
+		}
+
+		labels := model.LabelSet{
+			tritonLabelMachineID:        model.LabelValue(machine.ID),
+			tritonLabelMachineAlias:     model.LabelValue(machine.Name),
+			tritonLabelMachineBrand:     model.LabelValue(machine.Brand),
+			tritonLabelMachineImage:     model.LabelValue(machine.Image),
+			tritonLabelMachineState:     model.LabelValue(machine.State),
+			tritonLabelMachinePackage:   model.LabelValue(machine.Package),
+			tritonLabelMachinePrimaryIP: model.LabelValue(machine.PrimaryIP),
+			model.AddressLabel:          model.LabelValue(addr),
+		}
+
+		// machine.IPs and machine.Networks are parallel arrays; pair them
+		// up positionally to label each network IP under its resolved name.
+		for i, netID := range machine.Networks {
+			if i >= len(machine.IPs) {
+				break
+			}
+			name := networkNames[netID]
+			if name == "" {
+				name = netID
+			}
+			label := tritonLabelMachineNetworkPre + strutil.SanitizeLabelName(name)
+			labels[model.LabelName(label)] = model.LabelValue(machine.IPs[i])
+		}
+		for key, value := range machine.Tags {
+			name := strutil.SanitizeLabelName(key)
+			labels[model.LabelName(tritonLabelTagPre+name)] = model.LabelValue(value)
+		}
+
+		tg.Targets = append(tg.Targets, labels)
+	}
+
+	return []*targetgroup.Group{tg}, nil
+}
+
+// cloudAPIAddress picks the address label for a machine according to the
+// configured address_source.
+func (d *Discovery) cloudAPIAddress(machine cloudAPIMachine) (string, error) {
+	switch d.sdConfig.AddressSource {
+	case "primary_ip":
+		if machine.PrimaryIP == "" {
+			return "", errors.Errorf("triton SD machine %s has no primary IP", machine.ID)
+		}
+		return fmt.Sprintf("%s:%d", machine.PrimaryIP, d.sdConfig.Port), nil
+	case "dns":
+		if len(machine.DNSNames) == 0 {
+			return "", errors.Errorf("triton SD machine %s has no CNS dns_names", machine.ID)
+		}
+		return fmt.Sprintf("%s:%d", machine.DNSNames[0], d.sdConfig.Port), nil
+	default: // "uuid_dns"
+		return fmt.Sprintf("%s.%s:%d", machine.ID, d.sdConfig.DNSSuffix, d.sdConfig.Port), nil
+	}
+}
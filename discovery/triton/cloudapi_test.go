@@ -0,0 +1,211 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package triton
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestNextLinkURL(t *testing.T) {
+	cases := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{`<https://example.org/a/machines?offset=1000>; rel="next"`, "https://example.org/a/machines?offset=1000"},
+		{`<https://example.org/a/machines?offset=0>; rel="first", <https://example.org/a/machines?offset=1000>; rel="next"`, "https://example.org/a/machines?offset=1000"},
+		{`<https://example.org/a/machines?offset=0>; rel="last"`, ""},
+	}
+	for _, c := range cases {
+		if got := nextLinkURL(c.header); got != c.want {
+			t.Errorf("nextLinkURL(%q) = %q, want %q", c.header, got, c.want)
+		}
+	}
+}
+
+func TestCloudAPIAddress(t *testing.T) {
+	machine := cloudAPIMachine{
+		ID:        "4d2d1fbe-0041-4435-a6c2-3f6f9981b2cc",
+		PrimaryIP: "10.0.0.1",
+		DNSNames:  []string{"web0.inst.example.org"},
+	}
+
+	cases := []struct {
+		addressSource string
+		want          string
+		wantErr       bool
+	}{
+		{"primary_ip", "10.0.0.1:9163", false},
+		{"dns", "web0.inst.example.org:9163", false},
+		{"uuid_dns", "4d2d1fbe-0041-4435-a6c2-3f6f9981b2cc.example.org:9163", false},
+	}
+	for _, c := range cases {
+		d := &Discovery{sdConfig: &SDConfig{
+			Port:          9163,
+			DNSSuffix:     "example.org",
+			AddressSource: c.addressSource,
+		}}
+		got, err := d.cloudAPIAddress(machine)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("address_source %q: expected error, got nil", c.addressSource)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("address_source %q: unexpected error: %s", c.addressSource, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("address_source %q: got %q, want %q", c.addressSource, got, c.want)
+		}
+	}
+}
+
+func TestCloudAPIAddressMissingPrimaryIP(t *testing.T) {
+	d := &Discovery{sdConfig: &SDConfig{Port: 9163, AddressSource: "primary_ip"}}
+	if _, err := d.cloudAPIAddress(cloudAPIMachine{ID: "abc"}); err == nil {
+		t.Fatal("expected an error when primary_ip is requested but unavailable")
+	}
+}
+
+func TestRefreshCloudAPI(t *testing.T) {
+	const machinesPayload = `[
+		{
+			"id": "4d2d1fbe-0041-4435-a6c2-3f6f9981b2cc",
+			"name": "web0",
+			"brand": "lx",
+			"state": "running",
+			"image": "abc123",
+			"package": "g4-highcpu-1G",
+			"primaryIp": "10.0.0.1",
+			"ips": ["10.0.0.1"],
+			"networks": ["1e7bb0e4-c264-4ccb-983b-eed58749c3e2"],
+			"tags": {"Joyent-SDC-Public": "true"}
+		}
+	]`
+	const networksPayload = `[
+		{"id": "1e7bb0e4-c264-4ccb-983b-eed58749c3e2", "name": "Joyent-SDC-Public"}
+	]`
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/test/machines":
+			w.Header().Set("x-resource-count", "1")
+			w.Write([]byte(machinesPayload))
+		case "/test/networks":
+			w.Header().Set("x-resource-count", "1")
+			w.Write([]byte(networksPayload))
+		default:
+			t.Errorf("unexpected request path %q", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	host, portStr, err := net.SplitHostPort(strings.TrimPrefix(ts.URL, "https://"))
+	if err != nil {
+		t.Fatalf("error splitting test server address: %s", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatalf("error parsing test server port: %s", err)
+	}
+
+	d := &Discovery{
+		client: ts.Client(),
+		sdConfig: &SDConfig{
+			Account:       "test",
+			Endpoint:      host,
+			Port:          port,
+			AddressSource: "primary_ip",
+		},
+	}
+
+	groups, err := d.refreshCloudAPI(context.Background())
+	if err != nil {
+		t.Fatalf("refreshCloudAPI: %s", err)
+	}
+	if len(groups) != 1 || len(groups[0].Targets) != 1 {
+		t.Fatalf("expected a single target, got %+v", groups)
+	}
+
+	got := groups[0].Targets[0]
+	want := model.LabelSet{
+		model.AddressLabel:          model.LabelValue("10.0.0.1:" + portStr),
+		tritonLabelMachineID:        "4d2d1fbe-0041-4435-a6c2-3f6f9981b2cc",
+		tritonLabelMachineAlias:     "web0",
+		tritonLabelMachineBrand:     "lx",
+		tritonLabelMachineState:     "running",
+		tritonLabelMachineImage:     "abc123",
+		tritonLabelMachinePackage:   "g4-highcpu-1G",
+		tritonLabelMachinePrimaryIP: "10.0.0.1",
+		model.LabelName(tritonLabelMachineNetworkPre + "Joyent_SDC_Public"): "10.0.0.1",
+		model.LabelName(tritonLabelTagPre + "Joyent_SDC_Public"):            "true",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("labels = %#v, want %#v", got, want)
+	}
+}
+
+func TestFetchCloudAPINetworkNames(t *testing.T) {
+	const payload = `[
+		{"id": "1e7bb0e4-c264-4ccb-983b-eed58749c3e2", "name": "Joyent-SDC-Public"},
+		{"id": "45607081-4cd2-45c0-afea-649c9f6b2d6c", "name": "My-Fabric-Network"}
+	]`
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want := "/test/networks"; r.URL.Path != want {
+			t.Errorf("request path = %q, want %q", r.URL.Path, want)
+		}
+		w.Header().Set("x-resource-count", "2")
+		w.Write([]byte(payload))
+	}))
+	defer ts.Close()
+
+	host, portStr, err := net.SplitHostPort(strings.TrimPrefix(ts.URL, "https://"))
+	if err != nil {
+		t.Fatalf("error splitting test server address: %s", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatalf("error parsing test server port: %s", err)
+	}
+
+	d := &Discovery{
+		client:   ts.Client(),
+		sdConfig: &SDConfig{Account: "test", Endpoint: host, Port: port},
+	}
+
+	names, err := d.fetchCloudAPINetworkNames(context.Background())
+	if err != nil {
+		t.Fatalf("fetchCloudAPINetworkNames: %s", err)
+	}
+
+	want := map[string]string{
+		"1e7bb0e4-c264-4ccb-983b-eed58749c3e2": "Joyent-SDC-Public",
+		"45607081-4cd2-45c0-afea-649c9f6b2d6c": "My-Fabric-Network",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("names = %#v, want %#v", names, want)
+	}
+}
@@ -35,13 +35,18 @@ import (
 )
 
 const (
-	tritonLabel             = model.MetaLabelPrefix + "triton_"
-	tritonLabelGroups       = tritonLabel + "groups"
-	tritonLabelMachineID    = tritonLabel + "machine_id"
-	tritonLabelMachineAlias = tritonLabel + "machine_alias"
-	tritonLabelMachineBrand = tritonLabel + "machine_brand"
-	tritonLabelMachineImage = tritonLabel + "machine_image"
-	tritonLabelServerID     = tritonLabel + "server_id"
+	tritonLabel                  = model.MetaLabelPrefix + "triton_"
+	tritonLabelGroups            = tritonLabel + "groups"
+	tritonLabelMachineID         = tritonLabel + "machine_id"
+	tritonLabelMachineAlias      = tritonLabel + "machine_alias"
+	tritonLabelMachineBrand      = tritonLabel + "machine_brand"
+	tritonLabelMachineImage      = tritonLabel + "machine_image"
+	tritonLabelMachineState      = tritonLabel + "machine_state"
+	tritonLabelMachinePackage    = tritonLabel + "machine_package"
+	tritonLabelMachinePrimaryIP  = tritonLabel + "machine_primary_ip"
+	tritonLabelMachineNetworkPre = tritonLabel + "machine_network_"
+	tritonLabelTagPre            = tritonLabel + "tag_"
+	tritonLabelServerID          = tritonLabel + "server_id"
 )
 
 // DefaultSDConfig is the default Triton SD configuration.
@@ -50,11 +55,13 @@ var DefaultSDConfig = SDConfig{
 	Port:            9163,
 	RefreshInterval: model.Duration(60 * time.Second),
 	Version:         1,
+	AddressSource:   "uuid_dns",
 }
 
 // SDConfig is the configuration for Triton based service discovery.
 type SDConfig struct {
 	Account         string                `yaml:"account"`
+	Auth            *AuthConfig           `yaml:"auth,omitempty"`
 	ServerType      string                `yaml:"server_type,omitempty"`
 	DNSSuffix       string                `yaml:"dns_suffix"`
 	Endpoint        string                `yaml:"endpoint"`
@@ -63,6 +70,8 @@ type SDConfig struct {
 	RefreshInterval model.Duration        `yaml:"refresh_interval,omitempty"`
 	TLSConfig       config_util.TLSConfig `yaml:"tls_config,omitempty"`
 	Version         int                   `yaml:"version"`
+	Tags            map[string]string     `yaml:"tags,omitempty"`
+	AddressSource   string                `yaml:"address_source,omitempty"`
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
@@ -73,13 +82,16 @@ func (c *SDConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err != nil {
 		return err
 	}
-	if c.ServerType != "vm" && c.ServerType != "gz" {
-		return errors.New("triton SD configuration requires server_type to be 'vm' or 'gz'")
+	if c.ServerType != "vm" && c.ServerType != "gz" && c.ServerType != "cloudapi" {
+		return errors.New("triton SD configuration requires server_type to be 'vm', 'gz' or 'cloudapi'")
 	}
 	if c.Account == "" {
 		return errors.New("triton SD configuration requires an account")
 	}
-	if c.DNSSuffix == "" {
+	// dns_suffix is only actually used to synthesize addresses for the
+	// 'vm'/'gz' server types and for 'cloudapi' with address_source
+	// 'uuid_dns'; don't force fabric-only cloudapi users to set it.
+	if (c.ServerType != "cloudapi" || c.AddressSource == "uuid_dns") && c.DNSSuffix == "" {
 		return errors.New("triton SD configuration requires a dns_suffix")
 	}
 	if c.Endpoint == "" {
@@ -88,6 +100,17 @@ func (c *SDConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if c.RefreshInterval <= 0 {
 		return errors.New("triton SD configuration requires RefreshInterval to be a positive integer")
 	}
+	if c.AddressSource != "primary_ip" && c.AddressSource != "dns" && c.AddressSource != "uuid_dns" {
+		return errors.New("triton SD configuration requires address_source to be 'primary_ip', 'dns' or 'uuid_dns'")
+	}
+	if len(c.Tags) > 0 && c.ServerType != "cloudapi" {
+		return errors.New("triton SD configuration only supports tags filtering with server_type 'cloudapi'")
+	}
+	if c.Auth != nil {
+		if err := c.Auth.Validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -134,7 +157,16 @@ func New(logger log.Logger, conf *SDConfig) (*Discovery, error) {
 			conntrack.DialWithName("triton_sd"),
 		),
 	}
-	client := &http.Client{Transport: transport}
+
+	var rt http.RoundTripper = transport
+	if conf.Auth != nil {
+		signer, err := newRequestSigner(*conf.Auth)
+		if err != nil {
+			return nil, err
+		}
+		rt = &signingTransport{next: transport, signer: signer}
+	}
+	client := &http.Client{Transport: rt}
 
 	d := &Discovery{
 		client:   client,
@@ -151,6 +183,10 @@ func New(logger log.Logger, conf *SDConfig) (*Discovery, error) {
 }
 
 func (d *Discovery) refresh(ctx context.Context) ([]*targetgroup.Group, error) {
+	if d.sdConfig.ServerType == "cloudapi" {
+		return d.refreshCloudAPI(ctx)
+	}
+
 	var endpointFormat string
 	switch d.sdConfig.ServerType {
 	case "vm":